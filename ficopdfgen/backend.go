@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// --- Storage backends ---
+//
+// Backend abstracts the remote/local filesystem ficopdfgen watches and
+// writes PDFs back to. Everything in main.go used to call the sftp.Client
+// directly, which meant ficopdfgen could only ever point at an SFTP
+// server. Backend lets the poll loop and processFile stay storage-agnostic
+// while SFTPBackend, LocalBackend, and S3Backend each carry their own
+// transport details.
+type Backend interface {
+	// List returns the non-directory, non-hidden entry names directly
+	// under dir.
+	List(dir string) ([]string, error)
+	// Load reads the full contents of the file at path.
+	Load(path string) ([]byte, error)
+	// Save writes data to the file at path, creating or truncating it.
+	Save(path string, data []byte) error
+	// Stat reports whether path exists and its size.
+	Stat(path string) (os.FileInfo, error)
+	// Delete removes the file at path.
+	Delete(path string) error
+	// Rename moves the file at oldPath to newPath.
+	Rename(oldPath, newPath string) error
+	// MkdirAll ensures dir and all of its parents exist.
+	MkdirAll(dir string) error
+	// Claim atomically takes exclusive ownership of srcPath so that when
+	// two callers race to claim the same source only one can ever win,
+	// returning the path the winner should operate on afterward. Backends
+	// are free to implement this however their storage actually offers an
+	// atomicity guarantee (e.g. a rename that consumes its source, or a
+	// conditional write); see claim.go for how the result is used.
+	Claim(srcPath string) (string, error)
+	// ReleaseClaim lets a backend clean up any bookkeeping Claim created
+	// for srcPath (the pre-claim path) once processing has finished,
+	// successfully or not. Backends with nothing to clean up no-op.
+	ReleaseClaim(srcPath string) error
+}
+
+// newBackend builds the Backend selected by cfg.Backend.Type ("sftp" is the
+// default for backward compatibility with existing configs).
+func newBackend(cfg Config, sftpClient *sftp.Client) (Backend, error) {
+	switch cfg.Backend.Type {
+	case "", "sftp":
+		return &SFTPBackend{client: sftpClient, cfg: cfg}, nil
+	case "local":
+		if cfg.Backend.LocalRoot == "" {
+			return nil, fmt.Errorf("backend.local_root is required for the local backend")
+		}
+		return &LocalBackend{root: cfg.Backend.LocalRoot}, nil
+	case "s3":
+		return newS3Backend(cfg)
+	default:
+		return nil, fmt.Errorf("unknown backend type %q", cfg.Backend.Type)
+	}
+}
+
+// --- SFTPBackend ---
+
+// SFTPBackend implements Backend over an existing *sftp.Client, using the
+// chunked, resumable transfer helpers for Load/Save.
+type SFTPBackend struct {
+	client *sftp.Client
+	cfg    Config
+}
+
+func (b *SFTPBackend) List(dir string) ([]string, error) {
+	return listRemoteFiles(b.client, dir)
+}
+
+func (b *SFTPBackend) Load(remotePath string) ([]byte, error) {
+	// Keyed by the claim-suffix-stripped name, not remotePath's own
+	// basename: remotePath is a claimed path like "report.csv.processing.
+	// host.1234". Stripping the suffix back to "report.csv" keeps the
+	// local path - and so the transfer's .part journal - tied to the
+	// source file itself rather than to one specific claim attempt on it,
+	// so a resumed transfer actually finds its prior progress instead of
+	// starting over under what looks like a brand new filename.
+	localPath := filepath.Join(os.TempDir(), stripClaimSuffix(path.Base(remotePath)))
+	if err := downloadRemoteFileChunked(b.client, remotePath, localPath, b.cfg); err != nil {
+		return nil, err
+	}
+	defer os.Remove(localPath)
+	return os.ReadFile(localPath)
+}
+
+func (b *SFTPBackend) Save(remotePath string, data []byte) error {
+	localPath := filepath.Join(os.TempDir(), stripClaimSuffix(path.Base(remotePath))+".upload")
+	if err := os.WriteFile(localPath, data, 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(localPath)
+	return uploadLocalFileChunked(b.client, localPath, remotePath, b.cfg)
+}
+
+func (b *SFTPBackend) Stat(remotePath string) (os.FileInfo, error) {
+	return b.client.Stat(remotePath)
+}
+
+func (b *SFTPBackend) Delete(remotePath string) error {
+	return b.client.Remove(remotePath)
+}
+
+func (b *SFTPBackend) Rename(oldPath, newPath string) error {
+	return b.client.Rename(oldPath, newPath)
+}
+
+// MkdirAll walks dir segment by segment, creating each one. SFTP has no
+// native mkdir -p, and servers report "already exists" as a generic
+// SSH_FX_FAILURE rather than a distinct code, so that status is treated as
+// success rather than as an error.
+func (b *SFTPBackend) MkdirAll(dir string) error {
+	if dir == "" || dir == "." || dir == "/" {
+		return nil
+	}
+	var built string
+	for _, segment := range strings.Split(dir, "/") {
+		if segment == "" {
+			built = "/"
+			continue
+		}
+		built = path.Join(built, segment)
+		if err := b.client.Mkdir(built); err != nil {
+			var statusErr *sftp.StatusError
+			if errors.As(err, &statusErr) && statusErr.Code == uint32(sftp.ErrSSHFxFailure) {
+				continue
+			}
+			return fmt.Errorf("mkdir %s: %w", built, err)
+		}
+	}
+	return nil
+}
+
+// Claim renames srcPath to its claimed name; sftp's rename fails if srcPath
+// no longer exists, so only the first caller to reach it wins. The claimed
+// file's mtime is then set to now (best effort), since a rename keeps the
+// old one and the stale-claim check measures from it.
+func (b *SFTPBackend) Claim(srcPath string) (string, error) {
+	claimed := srcPath + claimSuffix()
+	if err := b.client.Rename(srcPath, claimed); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_ = b.client.Chtimes(claimed, now, now)
+	return claimed, nil
+}
+
+// ReleaseClaim is a no-op: the rename in Claim already consumed srcPath, so
+// there's no separate bookkeeping to clean up.
+func (b *SFTPBackend) ReleaseClaim(srcPath string) error { return nil }
+
+// --- LocalBackend ---
+
+// LocalBackend implements Backend against a directory on the local
+// filesystem, useful for testing or for pipelines that hand ficopdfgen
+// files over a shared mount instead of SFTP.
+type LocalBackend struct {
+	root string
+}
+
+func (b *LocalBackend) resolve(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(b.root, p)
+}
+
+func (b *LocalBackend) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(b.resolve(dir))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, e.Name())
+		}
+	}
+	return files, nil
+}
+
+func (b *LocalBackend) Load(p string) ([]byte, error) {
+	return os.ReadFile(b.resolve(p))
+}
+
+func (b *LocalBackend) Save(p string, data []byte) error {
+	return os.WriteFile(b.resolve(p), data, 0o644)
+}
+
+func (b *LocalBackend) Stat(p string) (os.FileInfo, error) {
+	return os.Stat(b.resolve(p))
+}
+
+func (b *LocalBackend) Delete(p string) error {
+	return os.Remove(b.resolve(p))
+}
+
+func (b *LocalBackend) Rename(oldPath, newPath string) error {
+	return os.Rename(b.resolve(oldPath), b.resolve(newPath))
+}
+
+func (b *LocalBackend) MkdirAll(dir string) error {
+	return os.MkdirAll(b.resolve(dir), 0o755)
+}
+
+// Claim renames srcPath to its claimed name. os.Rename requires srcPath to
+// exist, so once one caller's rename consumes it, every other caller's
+// rename of the same srcPath fails — the same source-consumption guarantee
+// SFTPBackend relies on. As there, the claimed file's mtime is set to now
+// (best effort) so staleness is measured from the claim.
+func (b *LocalBackend) Claim(srcPath string) (string, error) {
+	claimed := srcPath + claimSuffix()
+	if err := b.Rename(srcPath, claimed); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_ = os.Chtimes(b.resolve(claimed), now, now)
+	return claimed, nil
+}
+
+// ReleaseClaim is a no-op: the rename in Claim already consumed srcPath.
+func (b *LocalBackend) ReleaseClaim(srcPath string) error { return nil }
+
+// --- SemaphoreBackend ---
+
+// SemaphoreBackend wraps another Backend and caps the number of operations
+// in flight at once, regardless of how many goroutines call into it. This
+// is what keeps the ticker loop's unbounded "go processFile(...)" fan-out
+// from exhausting the SSH channel limit or hammering an object store.
+type SemaphoreBackend struct {
+	inner Backend
+	sem   chan struct{}
+}
+
+// NewSemaphoreBackend wraps inner so that at most `connections` operations
+// run concurrently. connections <= 0 disables the cap (mirrors inner).
+func NewSemaphoreBackend(inner Backend, connections int) Backend {
+	if connections <= 0 {
+		return inner
+	}
+	return &SemaphoreBackend{inner: inner, sem: make(chan struct{}, connections)}
+}
+
+func (b *SemaphoreBackend) acquire() func() {
+	b.sem <- struct{}{}
+	return func() { <-b.sem }
+}
+
+func (b *SemaphoreBackend) List(dir string) ([]string, error) {
+	defer b.acquire()()
+	return b.inner.List(dir)
+}
+
+func (b *SemaphoreBackend) Load(path string) ([]byte, error) {
+	defer b.acquire()()
+	return b.inner.Load(path)
+}
+
+func (b *SemaphoreBackend) Save(path string, data []byte) error {
+	defer b.acquire()()
+	return b.inner.Save(path, data)
+}
+
+func (b *SemaphoreBackend) Stat(path string) (os.FileInfo, error) {
+	defer b.acquire()()
+	return b.inner.Stat(path)
+}
+
+func (b *SemaphoreBackend) Delete(path string) error {
+	defer b.acquire()()
+	return b.inner.Delete(path)
+}
+
+func (b *SemaphoreBackend) Rename(oldPath, newPath string) error {
+	defer b.acquire()()
+	return b.inner.Rename(oldPath, newPath)
+}
+
+func (b *SemaphoreBackend) MkdirAll(dir string) error {
+	defer b.acquire()()
+	return b.inner.MkdirAll(dir)
+}
+
+func (b *SemaphoreBackend) Claim(srcPath string) (string, error) {
+	defer b.acquire()()
+	return b.inner.Claim(srcPath)
+}
+
+func (b *SemaphoreBackend) ReleaseClaim(srcPath string) error {
+	defer b.acquire()()
+	return b.inner.ReleaseClaim(srcPath)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// --- Idempotent file claiming ---
+//
+// processFile used to only check whether the output PDF already existed,
+// so two pollers (or two overlapping ticks of the same poller) could both
+// pick up the same source file. claimSource asks the backend to claim
+// dir/filename before anything reads it; each Backend implements Claim
+// with whatever atomicity guarantee its storage actually offers (SFTP and
+// LocalBackend rename to a per-worker name, since a rename that consumes
+// its source only ever succeeds for one caller; S3Backend uses a
+// conditional-write lock object instead, since its Rename is a non-atomic
+// copy+delete). Once a file is claimed it's moved into a "processed/" or
+// "failed/" subdirectory instead of being left (or re-read) in the watched
+// directory.
+//
+// A worker that crashes mid-processing leaves its claimed file sitting in
+// the watched directory under its claimed name forever, since nothing else
+// would ever rename it away. The poll loop in main.go uses isClaimedName and
+// staleClaimAfter to notice one of these and reprocess it; see
+// reclaimStaleFile. This only covers backends whose Claim renames the
+// source: an S3Backend lock object left by a crashed worker is never
+// expired, so that source stays unclaimable until the lock is removed.
+
+const (
+	defaultProcessedDir = "processed"
+	defaultFailedDir    = "failed"
+
+	claimMarker = ".processing."
+
+	defaultStaleClaimAfter = 15 * time.Minute
+)
+
+func claimSuffix() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s%s.%d", claimMarker, hostname, os.Getpid())
+}
+
+// isClaimedName reports whether name is already a claimed name, i.e. it
+// could be a file some worker claimed and then crashed before moving out of
+// the watched directory.
+func isClaimedName(name string) bool {
+	return strings.Contains(name, claimMarker)
+}
+
+// stripClaimSuffix returns the original filename a claimed name was derived
+// from, so a reclaimed file can be archived under its real name.
+func stripClaimSuffix(name string) string {
+	if idx := strings.Index(name, claimMarker); idx >= 0 {
+		return name[:idx]
+	}
+	return name
+}
+
+// staleClaimAfter returns how long a claimed file may sit untouched in the
+// watched directory before it's assumed abandoned by whatever worker
+// claimed it.
+func staleClaimAfter(cfg Config) time.Duration {
+	if cfg.Claim.StaleAfterSeconds <= 0 {
+		return defaultStaleClaimAfter
+	}
+	return time.Duration(cfg.Claim.StaleAfterSeconds) * time.Second
+}
+
+// claimSource asks backend to claim dir/filename, returning the claimed
+// path. If the backend reports another caller already owns it, that's
+// surfaced as an error the caller is expected to log and move on from.
+func claimSource(backend Backend, dir, filename string) (string, error) {
+	src := path.Join(dir, filename)
+	claimed, err := backend.Claim(src)
+	if err != nil {
+		return "", fmt.Errorf("claim %s: %w", filename, err)
+	}
+	return claimed, nil
+}
+
+// releaseClaim moves a claimed file into the processed/ or failed/
+// subdirectory of dir (created on demand) under originalFilename, the
+// caller-supplied name without the claim suffix, and lets the backend clean
+// up any claim bookkeeping tied to the original path.
+func releaseClaim(backend Backend, dir, claimedPath, originalFilename string, succeeded bool) error {
+	sub := defaultFailedDir
+	if succeeded {
+		sub = defaultProcessedDir
+	}
+	destDir := path.Join(dir, sub)
+	if err := backend.MkdirAll(destDir); err != nil {
+		return fmt.Errorf("create %s directory: %w", sub, err)
+	}
+	dest := path.Join(destDir, originalFilename)
+	if err := backend.Rename(claimedPath, dest); err != nil {
+		return fmt.Errorf("move claimed file to %s: %w", sub, err)
+	}
+	if err := backend.ReleaseClaim(path.Join(dir, originalFilename)); err != nil {
+		return fmt.Errorf("release claim bookkeeping: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+
+	"github.com/phpdave11/gofpdf"
+)
+
+// --- CSV table rendering ---
+//
+// csvToPDF used to split the page width evenly across columns and never
+// repeated the header on overflow, which made anything wider than a
+// handful of short columns unreadable. It now measures every column's
+// rendered width up front and distributes the page between them
+// proportionally, and (when the first row is a header) renders that row
+// with its own font/fill and repeats it at the top of every page via
+// gofpdf's header hook.
+
+const defaultMaxColWidthRatio = 0.6
+
+func boolOr(p *bool, def bool) bool {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+// parseHexColor turns "#rrggbb" (or "rrggbb") into 0-255 RGB components,
+// falling back to white for anything it can't parse.
+func parseHexColor(s string) (r, g, b int) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return 255, 255, 255
+	}
+	rv, err1 := strconv.ParseInt(s[0:2], 16, 32)
+	gv, err2 := strconv.ParseInt(s[2:4], 16, 32)
+	bv, err3 := strconv.ParseInt(s[4:6], 16, 32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 255, 255, 255
+	}
+	return int(rv), int(gv), int(bv)
+}
+
+// computeColumnWidths measures the rendered width of every cell in rows,
+// caps each column at maxRatio of usableWidth, and then spreads whatever
+// width is left (or missing) across columns in proportion to their
+// measured size, so narrow columns don't waste space and wide ones still
+// get to wrap instead of being crushed to an even share.
+func computeColumnWidths(pdf *gofpdf.Fpdf, rows [][]string, colCount int, usableWidth, maxRatio float64) []float64 {
+	if maxRatio <= 0 {
+		maxRatio = defaultMaxColWidthRatio
+	}
+	maxColWidth := usableWidth * maxRatio
+
+	raw := make([]float64, colCount)
+	for _, row := range rows {
+		for i := 0; i < colCount && i < len(row); i++ {
+			if w := pdf.GetStringWidth(row[i]) + 4; w > raw[i] {
+				raw[i] = w
+			}
+		}
+	}
+
+	capped := make([]float64, colCount)
+	var totalRaw, totalCapped float64
+	for i, w := range raw {
+		c := w
+		if c > maxColWidth {
+			c = maxColWidth
+		}
+		capped[i] = c
+		totalRaw += w
+		totalCapped += c
+	}
+
+	widths := make([]float64, colCount)
+	switch {
+	case totalCapped <= 0:
+		for i := range widths {
+			widths[i] = usableWidth / float64(colCount)
+		}
+	case totalCapped < usableWidth && totalRaw > 0:
+		leftover := usableWidth - totalCapped
+		for i := range widths {
+			widths[i] = capped[i] + leftover*(raw[i]/totalRaw)
+		}
+	default:
+		scale := usableWidth / totalCapped
+		for i := range widths {
+			widths[i] = capped[i] * scale
+		}
+	}
+	return widths
+}
+
+// --- CSV with auto-sized columns, repeating headers, and zebra striping ---
+func csvToPDF(cfg Config, data []byte, output string) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	records, err := r.ReadAll()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	pdf := gofpdf.New(cfg.PDF.Orientation, cfg.PDF.Unit, cfg.PDF.PageSize, "")
+	loadFonts(pdf, cfg)
+
+	// loadFonts only registers fonts; gofpdf has no current font until
+	// SetFont is called, and computeColumnWidths below measures cell text
+	// with GetStringWidth before a single row (or the header) has had the
+	// chance to select one. Select the body font up front so measurement
+	// doesn't panic on an unset font.
+	defaultFont := "normal"
+	if _, ok := cfg.Fonts[defaultFont]; !ok {
+		defaultFont = ""
+	}
+	pdf.SetFont(defaultFont, "", cfg.FontSize)
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	marginLeft, _, marginRight, marginBottom := pdf.GetMargins()
+	usableWidth := pageWidth - marginLeft - marginRight
+	lineHeight := cfg.FontSize * 1.2
+
+	colCount := len(records[0])
+	colWidths := computeColumnWidths(pdf, records, colCount, usableWidth, cfg.CSV.MaxColWidthRatio)
+
+	hasHeader := boolOr(cfg.CSV.HeaderRow, true)
+	var header []string
+	bodyRows := records
+	if hasHeader {
+		header = records[0]
+		bodyRows = records[1:]
+	}
+
+	if hasHeader && boolOr(cfg.CSV.FreezeHeader, true) {
+		pdf.SetHeaderFunc(func() {
+			renderHeaderRow(pdf, cfg, colWidths, header, lineHeight)
+		})
+	}
+
+	pdf.AddPage()
+	if hasHeader && !boolOr(cfg.CSV.FreezeHeader, true) {
+		renderHeaderRow(pdf, cfg, colWidths, header, lineHeight)
+	}
+
+	for rowIdx, row := range bodyRows {
+		if len(row) == 0 {
+			continue
+		}
+		xStart, y := pdf.GetXY()
+
+		// Compute row height for wrapping
+		rowHeight := lineHeight
+		for i := 0; i < colCount; i++ {
+			var cell string
+			if i < len(row) {
+				cell = row[i]
+			}
+			lines := pdf.SplitLines([]byte(cell), colWidths[i]-2)
+			if float64(len(lines))*lineHeight > rowHeight {
+				rowHeight = float64(len(lines)) * lineHeight
+			}
+		}
+
+		if cfg.CSV.ZebraStripe && rowIdx%2 == 1 {
+			fillZebraFill(pdf, cfg, xStart, y, usableWidth, rowHeight)
+		}
+
+		// Write each cell
+		cursorX := xStart
+		for i := 0; i < colCount; i++ {
+			var cell string
+			if i < len(row) {
+				cell = row[i]
+			}
+			pdf.SetXY(cursorX, y)
+			writeMultilineCell(pdf, cfg, colWidths[i], rowHeight, lineHeight, cell)
+			cursorX += colWidths[i]
+		}
+
+		// Move to next row
+		y += rowHeight
+		if y+rowHeight > pageHeight-marginBottom {
+			pdf.AddPage()
+			// AddPage already repainted the header via SetHeaderFunc (when
+			// configured) and left the cursor below it; marginTop would sit
+			// rows on top of that repeated header instead of under it.
+			y = pdf.GetY()
+		}
+		pdf.SetXY(xStart, y)
+	}
+
+	return pdf.OutputFileAndClose(output)
+}
+
+// renderHeaderRow draws the header row with its own font and background
+// fill. It's used both for the first page and, via SetHeaderFunc, for
+// every page gofpdf adds afterward.
+func renderHeaderRow(pdf *gofpdf.Fpdf, cfg Config, colWidths []float64, header []string, lineHeight float64) {
+	font := cfg.CSV.HeaderFont
+	if font == "" {
+		font = "normal"
+		if _, ok := cfg.Fonts[font]; !ok {
+			font = ""
+		}
+	}
+
+	fill := cfg.CSV.HeaderFill
+	if fill == "" {
+		fill = "#dddddd"
+	}
+	fr, fg, fb := parseHexColor(fill)
+	pdf.SetFillColor(fr, fg, fb)
+
+	marginLeft, marginTop, _, _ := pdf.GetMargins()
+	pdf.SetFont(font, "B", cfg.FontSize)
+
+	rowHeight := lineHeight
+	cellLines := make([][][]byte, len(colWidths))
+	for i := range colWidths {
+		var cell string
+		if i < len(header) {
+			cell = header[i]
+		}
+		cellLines[i] = pdf.SplitLines([]byte(cell), colWidths[i]-2)
+		if h := float64(len(cellLines[i])) * lineHeight; h > rowHeight {
+			rowHeight = h
+		}
+	}
+
+	cursorX := marginLeft
+	for i := range colWidths {
+		pdf.Rect(cursorX, marginTop, colWidths[i], rowHeight, "F")
+		cursorX += colWidths[i]
+	}
+
+	cursorX = marginLeft
+	for i := range colWidths {
+		y := marginTop
+		for _, line := range cellLines[i] {
+			pdf.SetXY(cursorX, y)
+			pdf.Write(lineHeight, string(line))
+			y += lineHeight
+		}
+		cursorX += colWidths[i]
+	}
+
+	pdf.SetXY(marginLeft, marginTop+rowHeight)
+}
+
+func fillZebraFill(pdf *gofpdf.Fpdf, cfg Config, x, y, w, h float64) {
+	fill := cfg.CSV.ZebraFill
+	if fill == "" {
+		fill = "#f5f5f5"
+	}
+	fr, fg, fb := parseHexColor(fill)
+	pdf.SetFillColor(fr, fg, fb)
+	pdf.Rect(x, y, w, h, "F")
+}
+
+func writeMultilineCell(pdf *gofpdf.Fpdf, cfg Config, w, h, lineHeight float64, text string) {
+	lines := pdf.SplitLines([]byte(text), w-2)
+	x, y := pdf.GetXY()
+	for _, line := range lines {
+		writeFormattedLineInline(pdf, cfg, string(line))
+		y += lineHeight
+		pdf.SetXY(x, y)
+	}
+}
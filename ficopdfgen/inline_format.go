@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/phpdave11/gofpdf"
+)
+
+// --- Inline formatting engine ---
+//
+// writeFormattedLineInline used to require a delimiter to wrap a single
+// whitespace-separated word, whole and unbroken (`*word*`), and only ever
+// applied one font. tokenizeInline instead scans the line rune by rune,
+// tracking a stack of currently-open rules so delimiters can nest
+// (`*bold _and italic_*`) and combine (a rule with Delimiter "**" and
+// Style "BI"), spans can cover multiple words and punctuation, and a
+// backslash escapes the character that follows it.
+
+// inlineToken is a run of text that shares a single font/style, as
+// produced by tokenizeInline. Space tokens hold the whitespace run they
+// stand for, but the renderer only advances past them by one space width.
+type inlineToken struct {
+	Text  string
+	Font  string
+	Style string
+	Space bool
+}
+
+// styledRune is one character of a line paired with the font/style active
+// at that position, with delimiter runes already consumed.
+type styledRune struct {
+	r     rune
+	font  string
+	style string
+}
+
+func sortedByDelimiterLength(rules []Rule) []Rule {
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Delimiter) > len(sorted[j].Delimiter)
+	})
+	return sorted
+}
+
+// composeStyle folds a stack of open rules into a single font + style,
+// with the innermost (most recently opened) font taking precedence and
+// style flags from every open rule combined without duplicates.
+func composeStyle(stack []Rule) (font, style string) {
+	seen := map[byte]bool{}
+	var b strings.Builder
+	for _, r := range stack {
+		if r.Font != "" {
+			font = r.Font
+		}
+		for i := 0; i < len(r.Style); i++ {
+			if c := r.Style[i]; !seen[c] {
+				seen[c] = true
+				b.WriteByte(c)
+			}
+		}
+	}
+	return font, b.String()
+}
+
+func styleRunes(line string, rules []Rule) []styledRune {
+	sorted := sortedByDelimiterLength(rules)
+	runes := []rune(line)
+	var stack []Rule
+	var out []styledRune
+
+	for i := 0; i < len(runes); {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			font, style := composeStyle(stack)
+			out = append(out, styledRune{r: runes[i+1], font: font, style: style})
+			i += 2
+			continue
+		}
+
+		matched := false
+		for _, r := range sorted {
+			dl := []rune(r.Delimiter)
+			if len(dl) == 0 || i+len(dl) > len(runes) {
+				continue
+			}
+			if string(runes[i:i+len(dl)]) != r.Delimiter {
+				continue
+			}
+			if len(stack) > 0 && stack[len(stack)-1].Delimiter == r.Delimiter {
+				stack = stack[:len(stack)-1]
+			} else {
+				stack = append(stack, r)
+			}
+			i += len(dl)
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+
+		font, style := composeStyle(stack)
+		out = append(out, styledRune{r: runes[i], font: font, style: style})
+		i++
+	}
+	return out
+}
+
+// tokenizeInline turns line into a sequence of word/space tokens, each
+// carrying the font and style active at that point, per cfg.Rules.
+func tokenizeInline(line string, rules []Rule) []inlineToken {
+	srs := styleRunes(line, rules)
+
+	var tokens []inlineToken
+	var cur strings.Builder
+	var curFont, curStyle string
+	curSpace, have := false, false
+
+	flush := func() {
+		if !have || cur.Len() == 0 {
+			return
+		}
+		tokens = append(tokens, inlineToken{Text: cur.String(), Font: curFont, Style: curStyle, Space: curSpace})
+		cur.Reset()
+	}
+
+	for _, sr := range srs {
+		isSpace := unicode.IsSpace(sr.r)
+		if !have || isSpace != curSpace || sr.font != curFont || sr.style != curStyle {
+			flush()
+			curSpace, curFont, curStyle, have = isSpace, sr.font, sr.style, true
+		}
+		cur.WriteRune(sr.r)
+	}
+	flush()
+	return tokens
+}
+
+// writeFormattedLineInline renders a single line, applying cfg.Rules'
+// nested/combined inline formatting and wrapping at the page margin.
+func writeFormattedLineInline(pdf *gofpdf.Fpdf, cfg Config, line string) {
+	pageWidth, pageHeight := pdf.GetPageSize()
+	marginLeft, marginTop, marginRight, marginBottom := pdf.GetMargins()
+	maxWidth := pageWidth - marginLeft - marginRight
+	lineHeight := cfg.FontSize * 1.2
+
+	xStart, y := pdf.GetXY()
+	cursorX := xStart
+
+	defaultFont := "normal"
+	if _, ok := cfg.Fonts[defaultFont]; !ok {
+		defaultFont = ""
+	}
+	pdf.SetFont(defaultFont, "", cfg.FontSize)
+
+	wrap := func() {
+		cursorX = marginLeft
+		y += lineHeight
+		if y+lineHeight > pageHeight-marginBottom {
+			pdf.AddPage()
+			y = marginTop
+		}
+	}
+
+	for _, tok := range tokenizeInline(line, cfg.Rules) {
+		if tok.Space {
+			spaceWidth := pdf.GetStringWidth(" ")
+			if cursorX+spaceWidth > maxWidth {
+				wrap()
+			} else {
+				cursorX += spaceWidth
+			}
+			pdf.SetXY(cursorX, y)
+			continue
+		}
+
+		font := tok.Font
+		if font == "" {
+			font = defaultFont
+		}
+		pdf.SetFont(font, tok.Style, cfg.FontSize)
+		word := tok.Text
+
+		for len(word) > 0 {
+			wordWidth := pdf.GetStringWidth(word)
+			remaining := maxWidth - cursorX
+
+			if wordWidth <= remaining {
+				pdf.SetXY(cursorX, y)
+				pdf.Write(lineHeight, word)
+				cursorX += wordWidth
+				word = ""
+			} else if wordWidth > maxWidth {
+				fit := 1
+				for fit <= len(word) && pdf.GetStringWidth(word[:fit]) <= maxWidth {
+					fit++
+				}
+				fit--
+				pdf.SetXY(cursorX, y)
+				pdf.Write(lineHeight, word[:fit])
+				word = word[fit:]
+				wrap()
+			} else {
+				wrap()
+			}
+		}
+		pdf.SetXY(cursorX, y)
+	}
+
+	pdf.SetXY(marginLeft, y+lineHeight)
+}
@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path"
@@ -24,6 +21,12 @@ type Config struct {
 		Port     int    `json:"port"`
 		User     string `json:"user"`
 		Password string `json:"password"`
+
+		AuthMethods          []string `json:"auth_methods"` // ordered: "ssh-agent", "key", "password"
+		PrivateKeyPath       string   `json:"private_key_path"`
+		PrivateKeyPassphrase string   `json:"private_key_passphrase"`
+		KnownHosts           bool     `json:"known_hosts"`
+		KnownHostsPath       string   `json:"known_hosts_path"`
 	} `json:"ssh"`
 
 	RemoteDirectory     string            `json:"remote_directory"`
@@ -32,17 +35,52 @@ type Config struct {
 	Fonts               map[string]string `json:"fonts"`
 	Rules               []Rule            `json:"rules"`
 
+	Transfer struct {
+		MaxConcurrentChunks int `json:"max_concurrent_chunks"`
+		ChunkSizeBytes      int `json:"chunk_size_bytes"`
+	} `json:"transfer"`
+
+	Claim struct {
+		// StaleAfterSeconds is how long a claimed file may sit untouched
+		// in the watched directory before the poll loop assumes whatever
+		// worker claimed it has crashed and takes over processing it.
+		// Defaults to 15 minutes.
+		StaleAfterSeconds int `json:"stale_after_seconds"`
+	} `json:"claim"`
+
+	Backend struct {
+		Type        string `json:"type"` // "sftp" (default), "local", "s3"
+		Connections int    `json:"connections"`
+		LocalRoot   string `json:"local_root"`
+		S3          struct {
+			Bucket string `json:"bucket"`
+			Region string `json:"region"`
+			Prefix string `json:"prefix"`
+		} `json:"s3"`
+	} `json:"backend"`
+
 	PDF struct {
 		Orientation string `json:"orientation"` // "P" or "L"
 		Unit        string `json:"unit"`        // "mm", "pt", "in"
 		PageSize    string `json:"page_size"`   // "A4", "Letter", etc.
 	} `json:"pdf"`
+
+	CSV struct {
+		HeaderRow        *bool   `json:"header_row"`    // treat the first row as a header; defaults to true
+		FreezeHeader     *bool   `json:"freeze_header"` // repeat the header row at the top of every page; defaults to true
+		MaxColWidthRatio float64 `json:"max_col_width_ratio"`
+		HeaderFont       string  `json:"header_font"`
+		HeaderFill       string  `json:"header_fill"` // hex color, e.g. "#dddddd"
+		ZebraStripe      bool    `json:"zebra_stripe"`
+		ZebraFill        string  `json:"zebra_fill"` // hex color, e.g. "#f5f5f5"
+	} `json:"csv"`
 }
 
 type Rule struct {
 	Name      string `json:"name"`
 	Delimiter string `json:"delimiter"`
 	Font      string `json:"font"`
+	Style     string `json:"style"` // gofpdf style flags to add: any of "B", "I", "U"
 }
 
 func main() {
@@ -62,20 +100,30 @@ func main() {
 		}
 	}
 
-	sshClient := connectSSH(cfg)
-	defer sshClient.Close()
+	var sftpClient *sftp.Client
+	if cfg.Backend.Type == "" || cfg.Backend.Type == "sftp" {
+		sshClient := connectSSH(cfg)
+		defer sshClient.Close()
 
-	sftpClient, err := sftp.NewClient(sshClient)
+		var err error
+		sftpClient, err = sftp.NewClient(sshClient)
+		if err != nil {
+			log.Fatal("Failed to create SFTP client:", err)
+		}
+		defer sftpClient.Close()
+	}
+
+	backend, err := newBackend(cfg, sftpClient)
 	if err != nil {
-		log.Fatal("Failed to create SFTP client:", err)
+		log.Fatal("Failed to initialize storage backend:", err)
 	}
-	defer sftpClient.Close()
+	backend = NewSemaphoreBackend(backend, cfg.Backend.Connections)
 
 	ticker := time.NewTicker(time.Duration(cfg.PollIntervalSeconds) * time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		files, err := listRemoteFiles(sftpClient, cfg.RemoteDirectory)
+		files, err := backend.List(cfg.RemoteDirectory)
 		if err != nil {
 			log.Println("Error listing files:", err)
 			continue
@@ -88,12 +136,20 @@ func main() {
 		log.Println("Found files:", files)
 		var wg sync.WaitGroup
 		for _, f := range files {
+			if isClaimedName(f) {
+				wg.Add(1)
+				go func(claimedName string) {
+					defer wg.Done()
+					reclaimStaleFile(cfg, backend, claimedName)
+				}(f)
+				continue
+			}
 			ext := strings.ToLower(f[len(f)-4:])
 			if ext == ".txt" || ext == ".csv" {
 				wg.Add(1)
 				go func(file string) {
 					defer wg.Done()
-					processFile(cfg, sftpClient, file)
+					processFile(cfg, backend, file)
 				}(f)
 			}
 		}
@@ -104,10 +160,19 @@ func main() {
 // --- SSH/SFTP helpers ---
 
 func connectSSH(cfg Config) *ssh.Client {
+	auths, err := buildAuthMethods(cfg)
+	if err != nil {
+		log.Fatal("Failed to set up SSH auth:", err)
+	}
+	hostKeyCallback, err := buildHostKeyCallback(cfg)
+	if err != nil {
+		log.Fatal("Failed to set up host key verification:", err)
+	}
+
 	conf := &ssh.ClientConfig{
 		User:            cfg.SSH.User,
-		Auth:            []ssh.AuthMethod{ssh.Password(cfg.SSH.Password)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         10 * time.Second,
 	}
 	addr := fmt.Sprintf("%s:%d", cfg.SSH.Host, cfg.SSH.Port)
@@ -132,30 +197,6 @@ func listRemoteFiles(client *sftp.Client, dir string) ([]string, error) {
 	return files, nil
 }
 
-func readRemoteFileSFTP(client *sftp.Client, remotePath string) ([]byte, error) {
-	f, err := client.Open(remotePath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	return io.ReadAll(f)
-}
-
-func uploadPDFSFTP(client *sftp.Client, remoteDir, localPDF string) error {
-	data, err := os.ReadFile(localPDF)
-	if err != nil {
-		return err
-	}
-	remotePath := path.Join(remoteDir, path.Base(localPDF))
-	f, err := client.Create(remotePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	_, err = f.Write(data)
-	return err
-}
-
 // --- PDF generation ---
 
 func loadFonts(pdf *gofpdf.Fpdf, cfg Config) {
@@ -164,84 +205,6 @@ func loadFonts(pdf *gofpdf.Fpdf, cfg Config) {
 	}
 }
 
-// Writes a single line with formatting rules
-func writeFormattedLineInline(pdf *gofpdf.Fpdf, cfg Config, line string) {
-	pageWidth, pageHeight := pdf.GetPageSize()
-	marginLeft, marginTop, marginRight, marginBottom := pdf.GetMargins()
-	maxWidth := pageWidth - marginLeft - marginRight
-	lineHeight := cfg.FontSize * 1.2
-
-	xStart, y := pdf.GetXY()
-	cursorX := xStart
-
-	words := strings.Fields(line)
-	currentFont := "normal"
-	if _, ok := cfg.Fonts[currentFont]; !ok {
-		currentFont = ""
-	}
-	pdf.SetFont(currentFont, "", cfg.FontSize)
-
-	for _, word := range words {
-		font := currentFont
-		for _, r := range cfg.Rules {
-			if strings.HasPrefix(word, r.Delimiter) && strings.HasSuffix(word, r.Delimiter) {
-				font = r.Font
-				word = word[len(r.Delimiter) : len(word)-len(r.Delimiter)]
-			}
-		}
-		pdf.SetFont(font, "", cfg.FontSize)
-		spaceWidth := pdf.GetStringWidth(" ")
-
-		for len(word) > 0 {
-			wordWidth := pdf.GetStringWidth(word)
-			remaining := maxWidth - cursorX
-
-			if wordWidth <= remaining {
-				pdf.SetXY(cursorX, y)
-				pdf.Write(lineHeight, word)
-				cursorX += wordWidth + spaceWidth
-				word = ""
-			} else if wordWidth > maxWidth {
-				fit := 1
-				for fit <= len(word) && pdf.GetStringWidth(word[:fit]) <= maxWidth {
-					fit++
-				}
-				fit--
-				pdf.SetXY(cursorX, y)
-				pdf.Write(lineHeight, word[:fit])
-				word = word[fit:]
-				cursorX = marginLeft
-				y += lineHeight
-				if y+lineHeight > pageHeight-marginBottom {
-					pdf.AddPage()
-					y = marginTop
-				}
-			} else {
-				cursorX = marginLeft
-				y += lineHeight
-				if y+lineHeight > pageHeight-marginBottom {
-					pdf.AddPage()
-					y = marginTop
-				}
-			}
-		}
-
-		if cursorX+spaceWidth > maxWidth {
-			cursorX = marginLeft
-			y += lineHeight
-			if y+lineHeight > pageHeight-marginBottom {
-				pdf.AddPage()
-				y = marginTop
-			}
-		} else {
-			cursorX += spaceWidth
-		}
-		pdf.SetXY(cursorX, y)
-	}
-
-	pdf.SetXY(marginLeft, y+lineHeight)
-}
-
 // --- TXT to PDF (preserving paragraph spacing) ---
 func txtToPDF(cfg Config, data []byte, output string) error {
 	pdf := gofpdf.New(cfg.PDF.Orientation, cfg.PDF.Unit, cfg.PDF.PageSize, "")
@@ -260,105 +223,80 @@ func txtToPDF(cfg Config, data []byte, output string) error {
 	return pdf.OutputFileAndClose(output)
 }
 
-// --- CSV with vertical auto-wrap ---
-func csvToPDF(cfg Config, data []byte, output string) error {
-	r := csv.NewReader(bytes.NewReader(data))
-	records, err := r.ReadAll()
-	if err != nil || len(records) == 0 {
-		return err
-	}
-
-	pdf := gofpdf.New(cfg.PDF.Orientation, cfg.PDF.Unit, cfg.PDF.PageSize, "")
-	loadFonts(pdf, cfg)
-	pdf.AddPage()
+// --- File processing ---
 
-	pageWidth, pageHeight := pdf.GetPageSize()
-	marginLeft, marginTop, marginRight, marginBottom := pdf.GetMargins()
-	usableWidth := pageWidth - marginLeft - marginRight
-	lineHeight := cfg.FontSize * 1.2
+func processFile(cfg Config, backend Backend, filename string) {
+	log.Println("Processing file:", filename)
+	pdfName := strings.TrimSuffix(filename, path.Ext(filename)) + ".pdf"
+	remotePDFPath := path.Join(cfg.RemoteDirectory, pdfName)
 
-	colCount := len(records[0])
-	colWidths := make([]float64, colCount)
-	for i := 0; i < colCount; i++ {
-		colWidths[i] = usableWidth / float64(colCount)
+	if _, err := backend.Stat(remotePDFPath); err == nil {
+		log.Println("PDF already exists, skipping:", pdfName)
+		return
 	}
 
-	for _, row := range records {
-		if len(row) == 0 {
-			continue
-		}
-		xStart, y := pdf.GetXY()
-
-		// Compute row height for wrapping
-		rowHeight := lineHeight
-		for i := 0; i < colCount; i++ {
-			var cell string
-			if i < len(row) {
-				cell = row[i]
-			}
-			lines := pdf.SplitLines([]byte(cell), colWidths[i]-2)
-			if float64(len(lines))*lineHeight > rowHeight {
-				rowHeight = float64(len(lines)) * lineHeight
-			}
-		}
-
-		// Write each cell
-		cursorX := xStart
-		for i := 0; i < colCount; i++ {
-			var cell string
-			if i < len(row) {
-				cell = row[i]
-			}
-			pdf.SetXY(cursorX, y)
-			writeMultilineCell(pdf, cfg, colWidths[i], rowHeight, lineHeight, cell)
-			cursorX += colWidths[i]
-		}
-
-		// Move to next row
-		y += rowHeight
-		if y+rowHeight > pageHeight-marginBottom {
-			pdf.AddPage()
-			y = marginTop
-		}
-		pdf.SetXY(xStart, y)
+	claimedPath, err := claimSource(backend, cfg.RemoteDirectory, filename)
+	if err != nil {
+		log.Println("Could not claim file, likely already taken:", err)
+		return
 	}
 
-	return pdf.OutputFileAndClose(output)
+	runClaimedFile(cfg, backend, claimedPath, filename)
 }
 
-func writeMultilineCell(pdf *gofpdf.Fpdf, cfg Config, w, h, lineHeight float64, text string) {
-	lines := pdf.SplitLines([]byte(text), w-2)
-	x, y := pdf.GetXY()
-	for _, line := range lines {
-		writeFormattedLineInline(pdf, cfg, string(line))
-		y += lineHeight
-		pdf.SetXY(x, y)
+// reclaimStaleFile is called for a file the poll loop's listing already
+// shows under a claimed name. If it's been sitting there longer than
+// staleClaimAfter, whatever worker claimed it is assumed to have crashed,
+// and processing resumes on the same claimed path rather than claiming it
+// again.
+func reclaimStaleFile(cfg Config, backend Backend, claimedName string) {
+	claimedPath := path.Join(cfg.RemoteDirectory, claimedName)
+	info, err := backend.Stat(claimedPath)
+	if err != nil {
+		// Gone already: another worker (or this one, moments ago) finished it.
+		return
+	}
+	if time.Since(info.ModTime()) < staleClaimAfter(cfg) {
+		return
 	}
+	log.Println("Reclaiming stale claimed file:", claimedName)
+	runClaimedFile(cfg, backend, claimedPath, stripClaimSuffix(claimedName))
 }
 
-// --- File processing ---
-
-func processFile(cfg Config, sftpClient *sftp.Client, filename string) {
-	log.Println("Processing file:", filename)
-	pdfName := strings.TrimSuffix(filename, path.Ext(filename)) + ".pdf"
+// runClaimedFile loads, renders, and uploads a file that's already been
+// claimed, releasing the claim into processed/ or failed/ once it's done.
+func runClaimedFile(cfg Config, backend Backend, claimedPath, originalFilename string) {
+	pdfName := strings.TrimSuffix(originalFilename, path.Ext(originalFilename)) + ".pdf"
 	remotePDFPath := path.Join(cfg.RemoteDirectory, pdfName)
 
-	if _, err := sftpClient.Stat(remotePDFPath); err == nil {
+	if _, err := backend.Stat(remotePDFPath); err == nil {
 		log.Println("PDF already exists, skipping:", pdfName)
+		if relErr := releaseClaim(backend, cfg.RemoteDirectory, claimedPath, originalFilename, true); relErr != nil {
+			log.Println("Failed to release claim:", relErr)
+		}
 		return
 	}
 
-	remotePath := path.Join(cfg.RemoteDirectory, filename)
-	data, err := readRemoteFileSFTP(sftpClient, remotePath)
+	data, err := backend.Load(claimedPath)
 	if err != nil {
-		log.Println("Failed to read remote file:", err)
+		log.Println("Failed to load claimed file:", err)
+		if relErr := releaseClaim(backend, cfg.RemoteDirectory, claimedPath, originalFilename, false); relErr != nil {
+			log.Println("Failed to release claim:", relErr)
+		}
 		return
 	}
 
 	localPDF := os.TempDir() + "/" + pdfName
-	ext := strings.ToLower(path.Ext(filename))
+	ext := strings.ToLower(path.Ext(originalFilename))
 
 	go func() {
+		succeeded := false
+		defer func() {
+			if relErr := releaseClaim(backend, cfg.RemoteDirectory, claimedPath, originalFilename, succeeded); relErr != nil {
+				log.Println("Failed to release claim:", relErr)
+			}
+		}()
+
 		var pdfErr error
 		if ext == ".txt" {
 			pdfErr = txtToPDF(cfg, data, localPDF)
@@ -369,10 +307,23 @@ func processFile(cfg Config, sftpClient *sftp.Client, filename string) {
 			log.Println("PDF generation failed:", pdfErr)
 			return
 		}
-		if err := uploadPDFSFTP(sftpClient, cfg.RemoteDirectory, localPDF); err != nil {
+		pdfData, err := os.ReadFile(localPDF)
+		if err != nil {
+			log.Println("Failed to read generated PDF:", err)
+			return
+		}
+
+		tmpPDFPath := remotePDFPath + ".tmp"
+		if err := backend.Save(tmpPDFPath, pdfData); err != nil {
 			log.Println("Upload failed:", err)
 			return
 		}
+		if err := backend.Rename(tmpPDFPath, remotePDFPath); err != nil {
+			log.Println("Failed to finalize uploaded PDF:", err)
+			return
+		}
+
+		succeeded = true
 		log.Println("PDF generated and uploaded successfully:", pdfName)
 	}()
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Backend implements Backend against an S3-compatible object store, with
+// every key prefixed by cfg.Backend.S3.Prefix so ficopdfgen can share a
+// bucket with other tenants.
+type S3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Backend(cfg Config) (*S3Backend, error) {
+	if cfg.Backend.S3.Bucket == "" {
+		return nil, errors.New("backend.s3.bucket is required for the s3 backend")
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion(cfg.Backend.S3.Region))
+	if err != nil {
+		return nil, err
+	}
+	return &S3Backend{
+		client: s3.NewFromConfig(awsCfg),
+		bucket: cfg.Backend.S3.Bucket,
+		prefix: cfg.Backend.S3.Prefix,
+	}, nil
+}
+
+func (b *S3Backend) key(p string) string {
+	return path.Join(b.prefix, p)
+}
+
+func (b *S3Backend) List(dir string) ([]string, error) {
+	ctx := context.Background()
+	prefix := b.key(dir) + "/"
+	out, err := b.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, obj := range out.Contents {
+		name := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+		if name != "" && !strings.HasPrefix(name, ".") {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+func (b *S3Backend) Load(p string) ([]byte, error) {
+	ctx := context.Background()
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(p)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (b *S3Backend) Save(p string, data []byte) error {
+	ctx := context.Background()
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(p)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (b *S3Backend) Stat(p string) (os.FileInfo, error) {
+	ctx := context.Background()
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(p)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return &s3FileInfo{name: path.Base(p), size: aws.ToInt64(out.ContentLength)}, nil
+}
+
+// lockKey returns the key of the lock object that guards p against being
+// claimed twice.
+func (b *S3Backend) lockKey(p string) string {
+	return b.key(p) + ".lock"
+}
+
+// Claim guards p with a lock object written under an If-None-Match: *
+// condition, which S3 only honors when no object already exists at that
+// key. Unlike Rename (a non-atomic copy-then-delete), this really can only
+// ever succeed for one caller, so p itself is returned unchanged — there's
+// no need to rename the source out of the way.
+func (b *S3Backend) Claim(p string) (string, error) {
+	ctx := context.Background()
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(b.lockKey(p)),
+		Body:        strings.NewReader(claimSuffix()),
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+			return "", fmt.Errorf("already claimed: %s", p)
+		}
+		return "", err
+	}
+	return p, nil
+}
+
+// ReleaseClaim removes the lock object Claim wrote for p.
+func (b *S3Backend) ReleaseClaim(p string) error {
+	ctx := context.Background()
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.lockKey(p)),
+	})
+	return err
+}
+
+func (b *S3Backend) Delete(p string) error {
+	ctx := context.Background()
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(p)),
+	})
+	return err
+}
+
+// Rename has no native S3 equivalent, so it's a copy of oldPath to newPath
+// followed by deleting oldPath — not atomic, since two concurrent callers
+// could both copy from oldPath before either deletes it. That's fine here:
+// every caller of Rename already holds the exclusive lock Claim grants, so
+// there's no concurrent caller left to race against.
+func (b *S3Backend) Rename(oldPath, newPath string) error {
+	ctx := context.Background()
+	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		Key:        aws.String(b.key(newPath)),
+		CopySource: aws.String(b.bucket + "/" + b.key(oldPath)),
+	})
+	if err != nil {
+		return err
+	}
+	return b.Delete(oldPath)
+}
+
+// MkdirAll is a no-op: S3 keys with slashes don't require a directory to
+// exist first.
+func (b *S3Backend) MkdirAll(dir string) error {
+	return nil
+}
+
+// s3FileInfo is a minimal os.FileInfo for S3 objects, which have no mode,
+// mod time, or directory concept worth modeling here.
+type s3FileInfo struct {
+	name string
+	size int64
+}
+
+func (i *s3FileInfo) Name() string       { return i.name }
+func (i *s3FileInfo) Size() int64        { return i.size }
+func (i *s3FileInfo) Mode() os.FileMode  { return 0 }
+func (i *s3FileInfo) ModTime() time.Time { return time.Time{} }
+func (i *s3FileInfo) IsDir() bool        { return false }
+func (i *s3FileInfo) Sys() interface{}   { return nil }